@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+var (
+	updateBatchWindow      = flag.Duration("update-batch-window", 50*time.Millisecond, "How long to accumulate duplicate update events for the same distribution before writing back a single decay round-trip")
+	updateMinDecayInterval = flag.Duration("update-min-decay-interval", time.Second, "Minimum interval between decay write-backs for the same distribution, even across batches")
+	decayLimiterSize       = flag.Int("decay-limiter-size", 65536, "Maximum number of distributions to remember decay timestamps for, bounding memory for the decay rate limiter")
+)
+
+// decayLimiter enforces updateMinDecayInterval across all update workers, so
+// a distribution can't be decayed more than once per period even when its
+// updates land in different batching windows. It's LRU-bounded, the same
+// way incrCache is, so a large or churning key space can't grow it without
+// limit.
+var decayLimiter *lru.Cache
+
+// decayLimiterMu makes allowDecay's check-and-record atomic across workers.
+var decayLimiterMu sync.Mutex
+
+func allowDecay(name string, minInterval time.Duration) bool {
+	decayLimiterMu.Lock()
+	defer decayLimiterMu.Unlock()
+	if v, ok := decayLimiter.Get(name); ok {
+		if time.Since(v.(time.Time)) < minInterval {
+			return false
+		}
+	}
+	decayLimiter.Add(name, time.Now())
+	return true
+}
+
+// UpdateRedis drains readChan into a per-window batch keyed by distribution
+// name, so a hot distribution receiving many increments in a short window
+// triggers a single Fill/Decay/WriteBack round-trip instead of one per
+// increment.
+func UpdateRedis(ctx context.Context, readChan chan *Distribution) {
+	ticker := time.NewTicker(*updateBatchWindow)
+	defer ticker.Stop()
+
+	batch := make(map[string]*Distribution)
+
+	flush := func() {
+		for _, dist := range batch {
+			processUpdate(ctx, dist)
+		}
+		batch = make(map[string]*Distribution)
+	}
+
+	for {
+		select {
+		case dist, ok := <-readChan:
+			if !ok {
+				flush()
+				return
+			}
+			updateQueueDepth.Set(float64(len(readChan)))
+			if existing, seen := batch[dist.Name]; !seen || (existing.Data == nil && dist.Data != nil) {
+				batch[dist.Name] = dist
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			flush()
+			return
+		}
+	}
+}
+
+// processUpdate performs the actual Fill/Decay/WriteBack round-trip for a
+// single distribution, subject to the decay rate limiter.
+func processUpdate(ctx context.Context, dist *Distribution) {
+	if !allowDecay(dist.Name, *updateMinDecayInterval) {
+		return
+	}
+
+	logger.Info().Str("distribution", dist.Name).Msg("updating distribution")
+	if dist.Data == nil {
+		if err := dist.Fill(ctx); err != nil {
+			logger.Error().Str("distribution", dist.Name).Err(err).Msg("could not update distribution")
+			return
+		}
+		if dist.T == 0 {
+			// No _T in the store yet: this distribution was just created
+			// and has never been decayed, so treat now as the baseline
+			// instead of letting Decay() see a multi-decade gap since the
+			// epoch and collapse the first batch of increments to ~0.
+			dist.T = int(time.Now().Unix())
+		}
+		if counts, z := incrCache.Take(dist.Name); len(counts) > 0 {
+			for k, n := range counts {
+				dist.Data[k] += n
+			}
+			dist.Z += z
+		}
+		dist.Decay()
+	}
+
+	start := time.Now()
+	err := store.WriteBack(ctx, dist)
+	timeStoreCall("write_back", start)
+	if err != nil {
+		logger.Error().Str("distribution", dist.Name).Err(err).Msg("could not update distribution")
+	} else {
+		decayWritebacksTotal.Inc()
+	}
+}
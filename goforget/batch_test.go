@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+type countingStore struct {
+	mu         sync.Mutex
+	writebacks int
+}
+
+func (s *countingStore) Incr(ctx context.Context, dist, field string, n int) error { return nil }
+
+func (s *countingStore) BulkIncr(ctx context.Context, incrs []Increment) error { return nil }
+
+func (s *countingStore) GetField(ctx context.Context, dist, field string) (count, Z, T int, rate float64, err error) {
+	return 0, 0, 0, 0, nil
+}
+
+func (s *countingStore) GetDist(ctx context.Context, dist string) (*Distribution, error) {
+	return &Distribution{Name: dist, Data: make(map[string]int)}, nil
+}
+
+func (s *countingStore) WriteBack(ctx context.Context, d *Distribution) error {
+	s.mu.Lock()
+	s.writebacks++
+	s.mu.Unlock()
+	return nil
+}
+
+func TestUpdateRedisCoalescesDuplicateNames(t *testing.T) {
+	origStore, origCache, origLimiter := store, incrCache, decayLimiter
+	defer func() { store, incrCache, decayLimiter = origStore, origCache, origLimiter }()
+
+	cs := &countingStore{}
+	store = cs
+	incrCache = NewIncrCache(16)
+	decayLimiter, _ = lru.New(*decayLimiterSize)
+
+	readChan := make(chan *Distribution, 100)
+	for i := 0; i < 20; i++ {
+		readChan <- &Distribution{Name: "hot"}
+	}
+	close(readChan)
+
+	UpdateRedis(context.Background(), readChan)
+
+	if cs.writebacks != 1 {
+		t.Errorf("expected exactly one write-back for a hot distribution receiving duplicate updates, got %d", cs.writebacks)
+	}
+}
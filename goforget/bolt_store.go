@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltPath = flag.String("bolt-path", "forgettable.db", "Path to the BoltDB database file used by the 'bolt' backend")
+
+var distributionsBucket = []byte("distributions")
+
+// BoltStore is an embedded, single-node Store backed by BoltDB, for
+// deployments that don't want to run a redis instance.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("could not open bolt database %s: %s", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(distributionsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not initialize bolt database %s: %s", path, err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltStore) load(tx *bolt.Tx, dist string) (*Distribution, error) {
+	d := &Distribution{Name: dist, Data: make(map[string]int)}
+	raw := tx.Bucket(distributionsBucket).Get([]byte(dist))
+	if raw == nil {
+		return d, nil
+	}
+	if err := json.Unmarshal(raw, d); err != nil {
+		return nil, fmt.Errorf("could not decode distribution %s: %s", dist, err)
+	}
+	return d, nil
+}
+
+func (s *BoltStore) save(tx *bolt.Tx, d *Distribution) error {
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(distributionsBucket).Put([]byte(d.Name), raw)
+}
+
+func (s *BoltStore) Incr(ctx context.Context, dist, field string, n int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		d, err := s.load(tx, dist)
+		if err != nil {
+			return err
+		}
+		d.Data[field] += n
+		d.Z += n
+		if d.T == 0 {
+			d.T = int(time.Now().Unix())
+		}
+		return s.save(tx, d)
+	})
+}
+
+func (s *BoltStore) BulkIncr(ctx context.Context, incrs []Increment) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		loaded := make(map[string]*Distribution)
+		for _, inc := range incrs {
+			d, ok := loaded[inc.Distribution]
+			if !ok {
+				var err error
+				d, err = s.load(tx, inc.Distribution)
+				if err != nil {
+					return err
+				}
+				loaded[inc.Distribution] = d
+			}
+			d.Data[inc.Field] += inc.N
+			d.Z += inc.N
+			if d.T == 0 {
+				d.T = int(time.Now().Unix())
+			}
+		}
+		for _, d := range loaded {
+			if err := s.save(tx, d); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) GetField(ctx context.Context, dist, field string) (count, Z, T int, rate float64, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		d, lerr := s.load(tx, dist)
+		if lerr != nil {
+			return lerr
+		}
+		count, Z, T, rate = d.Data[field], d.Z, d.T, d.Rate
+		return nil
+	})
+	return
+}
+
+func (s *BoltStore) GetDist(ctx context.Context, dist string) (*Distribution, error) {
+	var d *Distribution
+	err := s.db.View(func(tx *bolt.Tx) error {
+		loaded, lerr := s.load(tx, dist)
+		if lerr != nil {
+			return lerr
+		}
+		d = loaded
+		return nil
+	})
+	return d, err
+}
+
+func (s *BoltStore) WriteBack(ctx context.Context, d *Distribution) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return s.save(tx, d)
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+)
+
+var (
+	maxBulkIncrSize  = flag.Int("max-bulk-incr-size", 1000, "Maximum number of increments accepted in a single /bulk_incr request")
+	maxBulkIncrBytes = flag.Int64("max-bulk-incr-bytes", 1<<20, "Maximum size in bytes of a /bulk_incr request body, checked before it is decoded")
+)
+
+// BulkIncrEntry is a single increment as submitted in a /bulk_incr request
+// body. N is a pointer so an explicit "N": 0 can be told apart from a
+// missing field, the same way the query-string N on /incr is only
+// defaulted when absent.
+type BulkIncrEntry struct {
+	Distribution string `json:"distribution"`
+	Field        string `json:"field"`
+	N            *int   `json:"N"`
+}
+
+// BulkIncrResult is the /bulk_incr response body.
+type BulkIncrResult struct {
+	Accepted int      `json:"accepted"`
+	Failed   []string `json:"failed"`
+}
+
+// BulkIncrHandler accepts a JSON array of increments and applies all of
+// them in a single Store round-trip, so clients tracking many events per
+// user action don't pay one HTTP request per event.
+func BulkIncrHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		http.Error(w, "bulk_incr requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, *maxBulkIncrBytes)
+
+	var entries []BulkIncrEntry
+	if err := json.NewDecoder(r.Body).Decode(&entries); err != nil {
+		http.Error(w, fmt.Sprintf("could not decode request body: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if len(entries) > *maxBulkIncrSize {
+		http.Error(w, fmt.Sprintf("batch of %d increments exceeds maximum of %d", len(entries), *maxBulkIncrSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	result := BulkIncrResult{Failed: []string{}}
+	incrs := make([]Increment, 0, len(entries))
+	dists := make(map[string]bool, len(entries))
+
+	for _, e := range entries {
+		if e.Distribution == "" || e.Field == "" {
+			result.Failed = append(result.Failed, fmt.Sprintf("%s/%s: missing distribution or field", e.Distribution, e.Field))
+			continue
+		}
+		n := 1
+		if e.N != nil {
+			n = *e.N
+		}
+		incrs = append(incrs, Increment{Distribution: e.Distribution, Field: e.Field, N: n})
+		dists[e.Distribution] = true
+	}
+
+	if len(incrs) > 0 {
+		if err := store.BulkIncr(ctx, incrs); err != nil {
+			logger.Error().Err(err).Int("count", len(incrs)).Msg("bulk incr failed")
+			for _, inc := range incrs {
+				result.Failed = append(result.Failed, fmt.Sprintf("%s/%s: %s", inc.Distribution, inc.Field, err))
+			}
+		} else {
+			result.Accepted = len(incrs)
+			for dist := range dists {
+				enqueueUpdate(&Distribution{Name: dist})
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
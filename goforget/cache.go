@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+var (
+	cacheSize          = flag.Int("incr-cache-size", 4096, "Maximum number of distributions to hold pending increments for before the LRU evicts and flushes the least recently used")
+	cacheFlushInterval = flag.Duration("incr-cache-flush-interval", 250*time.Millisecond, "How often to flush accumulated increments from the write-back cache to redis")
+)
+
+var incrCache *IncrCache
+
+// distDelta accumulates increments for a single distribution's fields
+// between flushes, so a burst of /incr calls for the same field collapses
+// into one HINCRBY pipeline per flush interval instead of one per request.
+type distDelta struct {
+	mu     sync.Mutex
+	counts map[string]int
+	z      int
+}
+
+// IncrCache is an in-process write-back cache that sits in front of redis
+// for the hot /incr path. Increments accumulate here and are flushed in
+// HINCRBY pipelines either on a timer (Run) or when the LRU evicts a
+// distribution to make room for another one.
+type IncrCache struct {
+	lru *lru.Cache // name -> *distDelta
+}
+
+func NewIncrCache(size int) *IncrCache {
+	c := &IncrCache{}
+	l, err := lru.NewWithEvict(size, func(key interface{}, value interface{}) {
+		c.flushOne(key.(string), value.(*distDelta))
+	})
+	if err != nil {
+		logger.Fatal().Err(err).Msg("could not create incr cache")
+	}
+	c.lru = l
+	return c
+}
+
+// Add accumulates n into the pending delta for (dist, field).
+func (c *IncrCache) Add(dist, field string, n int) {
+	v, ok := c.lru.Get(dist)
+	var d *distDelta
+	if !ok {
+		d = &distDelta{counts: make(map[string]int)}
+		c.lru.Add(dist, d)
+	} else {
+		d = v.(*distDelta)
+	}
+
+	d.mu.Lock()
+	d.counts[field] += n
+	d.z += n
+	d.mu.Unlock()
+}
+
+// Pending returns a copy of the not-yet-flushed counts and Z delta for dist,
+// for merging into a /get or /dist response that would otherwise undercount
+// recent increments still sitting in the cache.
+func (c *IncrCache) Pending(dist string) (counts map[string]int, z int) {
+	v, ok := c.lru.Peek(dist)
+	if !ok {
+		return nil, 0
+	}
+	d := v.(*distDelta)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	counts = make(map[string]int, len(d.counts))
+	for k, n := range d.counts {
+		counts[k] = n
+	}
+	return counts, d.z
+}
+
+// Take removes and returns dist's pending delta without flushing it to
+// redis, for callers (such as the decay write-back path) that are about to
+// persist the merged counts themselves and would otherwise double-count
+// them when the cache flushes on its own.
+func (c *IncrCache) Take(dist string) (counts map[string]int, z int) {
+	v, ok := c.lru.Peek(dist)
+	if !ok {
+		return nil, 0
+	}
+	d := v.(*distDelta)
+
+	d.mu.Lock()
+	counts, z = d.counts, d.z
+	d.counts, d.z = make(map[string]int), 0
+	d.mu.Unlock()
+
+	c.lru.Remove(dist) // drives flushOne, which is now a no-op on the cleared delta
+
+	return counts, z
+}
+
+// FlushAll writes back every pending delta, for use on a timer and on
+// shutdown.
+func (c *IncrCache) FlushAll() {
+	for _, key := range c.lru.Keys() {
+		c.lru.Remove(key)
+	}
+}
+
+func (c *IncrCache) flushOne(dist string, d *distDelta) {
+	d.mu.Lock()
+	counts := d.counts
+	d.mu.Unlock()
+
+	if len(counts) == 0 {
+		return
+	}
+
+	ctx := context.Background()
+	for field, n := range counts {
+		start := time.Now()
+		err := store.Incr(ctx, dist, field, n)
+		timeStoreCall("incr", start)
+		if err != nil {
+			logger.Error().Str("distribution", dist).Str("field", field).Err(err).Msg("could not flush pending increment")
+		}
+	}
+}
+
+// Run flushes accumulated increments every interval until ctx is cancelled,
+// performing one last flush before returning so a SIGTERM doesn't drop
+// increments that hadn't hit their timer yet.
+func (c *IncrCache) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.FlushAll()
+		case <-ctx.Done():
+			c.FlushAll()
+			return
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestIncrCacheAddAccumulates(t *testing.T) {
+	c := NewIncrCache(16)
+	c.Add("d", "f", 1)
+	c.Add("d", "f", 2)
+	c.Add("d", "g", 5)
+
+	counts, z := c.Pending("d")
+	if counts["f"] != 3 {
+		t.Errorf("expected f=3, got %d", counts["f"])
+	}
+	if counts["g"] != 5 {
+		t.Errorf("expected g=5, got %d", counts["g"])
+	}
+	if z != 8 {
+		t.Errorf("expected z=8, got %d", z)
+	}
+}
+
+func TestIncrCachePendingIsUnknownDistribution(t *testing.T) {
+	c := NewIncrCache(16)
+	counts, z := c.Pending("missing")
+	if counts != nil || z != 0 {
+		t.Errorf("expected no pending delta for unknown distribution, got %v, %d", counts, z)
+	}
+}
+
+func TestIncrCacheTakeClearsPending(t *testing.T) {
+	c := NewIncrCache(16)
+	c.Add("d", "f", 3)
+
+	counts, z := c.Take("d")
+	if counts["f"] != 3 || z != 3 {
+		t.Errorf("expected taken delta f=3, z=3, got %v, %d", counts, z)
+	}
+
+	if counts, z := c.Pending("d"); counts != nil || z != 0 {
+		t.Errorf("expected delta to be gone after Take, got %v, %d", counts, z)
+	}
+}
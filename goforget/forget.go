@@ -1,27 +1,29 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"github.com/garyburd/redigo/redis"
-	"log"
+	"io"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
+
+	lru "github.com/hashicorp/golang-lru"
 )
 
 var (
-	redisHost   = flag.String("redis-host", "", "Redis host in the form host:port:db.")
 	defaultRate = flag.Float64("default-rate", 0.5, "Default rate to decay distributions with")
 	nWorkers    = flag.Int("nworkers", 1, "Number of update workers that update the redis DB")
 )
 
-var rdb redis.Conn
-var rLock sync.RWMutex
 var updateChan chan *Distribution
 
 type SingleResult struct {
@@ -41,45 +43,17 @@ type Distribution struct {
 	Rate float64        `json:"rate"`
 }
 
-func (d *Distribution) Fill() error {
-	rLock.RLock()
-	data, err := redis.MultiBulk(rdb.Do("HGETALL", d.Name))
-	rLock.RUnlock()
-
+func (d *Distribution) Fill(ctx context.Context) error {
+	start := time.Now()
+	full, err := store.GetDist(ctx, d.Name)
+	timeStoreCall("get_dist", start)
 	if err != nil {
-		return fmt.Errorf("Could not fetch data for %s: %s", d.Name, err)
-	}
-
-	// TODO: don't use the dist map to speed things up!
-	d.Data = make(map[string]int)
-	d.Z = 0
-	for i := 0; i < len(data); i += 2 {
-		k, err := redis.String(data[i], nil)
-		if err != nil || k == "" {
-			log.Printf("Could not read %s from distribution %s: %s", data[i], d.Name, err)
-		}
-		if k == "_R" {
-			var rate float64
-			n, err := fmt.Fscan(strings.NewReader(data[i+1].(string)), &rate)
-			if n == 1 && err == nil {
-				d.Rate = rate
-			}
-		} else {
-			v, err := redis.Int(data[i+1], nil)
-			if err != nil {
-				log.Printf("Could not read %s from distribution %s: %s", data[i+1], d.Name, err)
-			}
-			if k == "_Z" {
-				continue
-			} else if k == "_T" {
-				d.T = v
-			} else {
-				d.Data[k] = v
-				d.Z += v
-			}
-		}
+		return err
 	}
-
+	d.Data = full.Data
+	d.Z = full.Z
+	d.T = full.T
+	d.Rate = full.Rate
 	return nil
 }
 
@@ -92,55 +66,6 @@ func (d *Distribution) Decay() {
 	d.T = int(time.Now().Unix())
 }
 
-func UpdateRedis(readChan chan *Distribution) {
-	var err error
-	for dist := range readChan {
-		log.Printf("Updating distribution: %s", dist.Name)
-		if dist.Data == nil {
-			dist.Fill()
-			if err != nil {
-				log.Printf("Could not update %s: %s", dist.Name, err)
-				continue
-			}
-			dist.Decay()
-		}
-
-		rLock.Lock()
-		rdb.Send("MULTI")
-		for k, v := range dist.Data {
-			rdb.Send("HSET", dist.Name, k, v)
-		}
-		rdb.Send("HSET", dist.Name, "_Z", dist.Z)
-		rdb.Send("HSET", dist.Name, "_T", dist.T)
-		_, err := rdb.Do("EXEC")
-		rLock.Unlock()
-		if err != nil {
-			log.Printf("Could not update %s: %s", dist.Name, err)
-		}
-	}
-}
-
-func ConnectRedis() redis.Conn {
-	parts := strings.Split(*redisHost, ":")
-
-	if len(parts) != 3 {
-		log.Panicf("redis-host must be in the form host:port:db")
-	}
-
-	db, err := redis.Dial("tcp", fmt.Sprintf("%s:%s", parts[0], parts[1]))
-	if err == nil {
-		ok, err := db.Do("SELECT", parts[2])
-		if ok != "OK" || err != nil {
-			log.Panicf("Could not change to DB %s: %s", parts[2], ok)
-		}
-	} else {
-		log.Panicf("Could not connect: %s", err)
-	}
-
-	log.Printf("Connected to %s", *redisHost)
-	return db
-}
-
 func IncrHandler(w http.ResponseWriter, r *http.Request) {
 	reqParams, err := url.ParseQuery(r.URL.RawQuery)
 	if err != nil {
@@ -169,23 +94,14 @@ func IncrHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	rLock.Lock()
-	rdb.Send("MULTI")
-	rdb.Send("HINCRBY", distribution, field, N)
-	rdb.Send("HINCRBY", distribution, "_Z", N)
-	rdb.Send("HSETNX", distribution, "_T", int(time.Now().Unix()))
-	_, err = rdb.Do("EXEC")
-	rLock.Unlock()
+	incrCache.Add(distribution, field, N)
 
-	if err == nil {
-		fmt.Fprintf(w, "OK")
-	} else {
-		fmt.Fprintf(w, "FAIL")
-	}
-	updateChan <- &Distribution{Name: distribution}
+	fmt.Fprintf(w, "OK")
+	enqueueUpdate(&Distribution{Name: distribution})
 }
 
 func DistHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	reqParams, err := url.ParseQuery(r.URL.RawQuery)
 	if err != nil {
 		fmt.Fprintf(w, "Error decoding request URI: %s\n", r.URL.RawQuery)
@@ -208,16 +124,29 @@ func DistHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	dist := Distribution{Name: distribution}
-	err = dist.Fill()
+	err = dist.Fill(ctx)
 	if err != nil {
 		fmt.Fprintf(w, "Error retrieving distribution %s: %s", distribution, err)
 		return
 	}
+	if dist.T == 0 {
+		// No _T in the store yet: this distribution was just created and
+		// has never been decayed, so treat now as the baseline instead of
+		// decaying the response against the 1970 epoch.
+		dist.T = int(time.Now().Unix())
+	}
 
 	if dist.Rate == *defaultRate {
 		dist.Rate = rate
 	}
 
+	if pending, z := incrCache.Pending(distribution); len(pending) > 0 {
+		for k, n := range pending {
+			dist.Data[k] += n
+		}
+		dist.Z += z
+	}
+
 	dist.Decay()
 	j, err := json.Marshal(dist)
 	if err != nil {
@@ -226,10 +155,14 @@ func DistHandler(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "%s", j)
 	}
 
-	updateChan <- &dist
+	// Enqueue a nil-Data update so the worker re-Fills and Take()s
+	// incrCache itself; writing back this handler's own Pending() copy
+	// would double-persist these deltas when the cache flushes them too.
+	enqueueUpdate(&Distribution{Name: distribution})
 }
 
 func GetHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	reqParams, err := url.ParseQuery(r.URL.RawQuery)
 	if err != nil {
 		fmt.Fprintf(w, "Error decoding request URI: %s\n", r.URL.RawQuery)
@@ -256,16 +189,23 @@ func GetHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	rLock.RLock()
-	data, err := redis.MultiBulk(rdb.Do("HMGET", distribution, field, "_Z", "_T"))
-	rLock.RUnlock()
-
-	if err != nil || len(data) != 3 {
+	start := time.Now()
+	count, Z, t, _, err := store.GetField(ctx, distribution, field)
+	timeStoreCall("get_field", start)
+	if err != nil {
 		fmt.Fprintf(w, "Error retrieving field %s: %s", field, err)
 	} else {
-		count, _ := redis.Int(data[0], nil)
-		Z, _ := redis.Int(data[1], nil)
-		t, _ := redis.Int(data[2], nil)
+		if pending, z := incrCache.Pending(distribution); len(pending) > 0 {
+			count += pending[field]
+			Z += z
+		}
+
+		if t == 0 {
+			// No _T in the store yet: this distribution was just created and
+			// has never been decayed, so treat now as the baseline instead of
+			// decaying the response against the 1970 epoch.
+			t = int(time.Now().Unix())
+		}
 
 		count, Z = Decay(count, Z, t, rate)
 		var p float64
@@ -290,23 +230,68 @@ func GetHandler(w http.ResponseWriter, r *http.Request) {
 			fmt.Fprintf(w, "%s", j)
 		}
 
-		updateChan <- &Distribution{Name: distribution}
+		enqueueUpdate(&Distribution{Name: distribution})
 	}
 }
 
 func main() {
 	flag.Parse()
 
-	rdb = ConnectRedis()
+	var err error
+	store, err = NewStore(*backend)
+	if err != nil {
+		logger.Fatal().Str("backend", *backend).Err(err).Msg("could not initialize backend")
+	}
+	incrCache = NewIncrCache(*cacheSize)
 
-	log.Printf("Starting %d update workers", *nWorkers)
+	var lruErr error
+	decayLimiter, lruErr = lru.New(*decayLimiterSize)
+	if lruErr != nil {
+		logger.Fatal().Err(lruErr).Msg("could not create decay limiter")
+	}
+
+	logger.Info().Int("workers", *nWorkers).Msg("starting update workers")
 	updateChan = make(chan *Distribution, 10) //25 * *nWorkers)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var workersDone sync.WaitGroup
 	for i := 0; i < *nWorkers; i++ {
-		go UpdateRedis(updateChan)
+		workersDone.Add(1)
+		go func() {
+			defer workersDone.Done()
+			UpdateRedis(ctx, updateChan)
+		}()
 	}
 
-	http.HandleFunc("/get", GetHandler)
-	http.HandleFunc("/incr", IncrHandler)
-	http.HandleFunc("/dist", DistHandler)
-	log.Fatal(http.ListenAndServe(":6666", nil))
+	var cacheDone sync.WaitGroup
+	cacheDone.Add(1)
+	go func() {
+		defer cacheDone.Done()
+		incrCache.Run(ctx, *cacheFlushInterval)
+	}()
+
+	instrumented("/get", "get", GetHandler)
+	instrumented("/incr", "incr", IncrHandler)
+	instrumented("/dist", "dist", DistHandler)
+	instrumented("/bulk_incr", "bulk_incr", BulkIncrHandler)
+	registerMetricsHandler()
+	go func() {
+		if err := http.ListenAndServe(":6666", nil); err != nil {
+			logger.Fatal().Err(err).Msg("http server exited")
+		}
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
+	<-sigChan
+	logger.Info().Msg("received shutdown signal, flushing pending increments")
+	cancel()
+	cacheDone.Wait()
+	workersDone.Wait()
+
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logger.Error().Err(err).Msg("error closing store")
+		}
+	}
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeReadStore serves a fixed, already-persisted distribution, so tests can
+// check that handlers merge incrCache's pending deltas into it before
+// responding.
+type fakeReadStore struct {
+	count, z, t int
+	rate        float64
+}
+
+func (s *fakeReadStore) Incr(ctx context.Context, dist, field string, n int) error { return nil }
+
+func (s *fakeReadStore) BulkIncr(ctx context.Context, incrs []Increment) error { return nil }
+
+func (s *fakeReadStore) GetField(ctx context.Context, dist, field string) (count, Z, T int, rate float64, err error) {
+	return s.count, s.z, s.t, s.rate, nil
+}
+
+func (s *fakeReadStore) GetDist(ctx context.Context, dist string) (*Distribution, error) {
+	return &Distribution{Name: dist, Data: map[string]int{"f": s.count}, Z: s.z, T: s.t, Rate: s.rate}, nil
+}
+
+func (s *fakeReadStore) WriteBack(ctx context.Context, d *Distribution) error { return nil }
+
+func TestGetHandlerMergesPendingIncrements(t *testing.T) {
+	origStore, origCache, origChan := store, incrCache, updateChan
+	defer func() { store, incrCache, updateChan = origStore, origCache, origChan }()
+
+	store = &fakeReadStore{count: 10, z: 10, t: int(time.Now().Unix()), rate: 0.5}
+	incrCache = NewIncrCache(16)
+	incrCache.Add("d", "f", 5)
+	updateChan = make(chan *Distribution, 10)
+
+	req := httptest.NewRequest(http.MethodGet, "/get?distribution=d&field=f&rate=0.5", nil)
+	w := httptest.NewRecorder()
+	GetHandler(w, req)
+
+	var result SingleResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("could not decode response %q: %s", w.Body.String(), err)
+	}
+	if result.Count != 15 {
+		t.Errorf("expected count to merge 10 stored + 5 pending = 15, got %d", result.Count)
+	}
+}
+
+func TestDistHandlerMergesPendingIncrements(t *testing.T) {
+	origStore, origCache, origChan := store, incrCache, updateChan
+	defer func() { store, incrCache, updateChan = origStore, origCache, origChan }()
+
+	store = &fakeReadStore{count: 10, z: 10, t: int(time.Now().Unix()), rate: 0.5}
+	incrCache = NewIncrCache(16)
+	incrCache.Add("d", "f", 5)
+	updateChan = make(chan *Distribution, 10)
+
+	req := httptest.NewRequest(http.MethodGet, "/dist?distribution=d&rate=0.5", nil)
+	w := httptest.NewRecorder()
+	DistHandler(w, req)
+
+	var result Distribution
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("could not decode response %q: %s", w.Body.String(), err)
+	}
+	if result.Data["f"] != 15 {
+		t.Errorf("expected field f to merge 10 stored + 5 pending = 15, got %d", result.Data["f"])
+	}
+}
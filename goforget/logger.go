@@ -0,0 +1,12 @@
+package main
+
+import (
+	"os"
+
+	"github.com/rs/zerolog"
+)
+
+// logger is the structured logger used throughout the service, so that
+// distribution names, field names, and error codes become queryable
+// fields instead of being interpolated into a free-form message.
+var logger = zerolog.New(os.Stdout).With().Timestamp().Logger()
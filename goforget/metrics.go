@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var updateQueueDrop = flag.Bool("update-queue-drop", false, "Drop update events instead of blocking the handler when the update queue is full")
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "forgettable_http_requests_total",
+		Help: "Total number of HTTP requests handled, by handler.",
+	}, []string{"handler"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "forgettable_http_request_duration_seconds",
+		Help: "Latency of HTTP handlers, by handler.",
+	}, []string{"handler"})
+
+	updateQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "forgettable_update_queue_depth",
+		Help: "Number of distributions currently queued for decay write-back.",
+	})
+
+	updateQueueDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "forgettable_update_queue_dropped_total",
+		Help: "Number of update events dropped because the update queue was full.",
+	})
+
+	storeCommandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "forgettable_store_command_duration_seconds",
+		Help: "Latency of Store calls, by operation.",
+	}, []string{"op"})
+
+	decayWritebacksTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "forgettable_decay_writebacks_total",
+		Help: "Number of distributions written back to the store after decay.",
+	})
+)
+
+// instrumented wraps h to record a request count and latency histogram
+// under name, and registers it with http.DefaultServeMux.
+func instrumented(pattern, name string, h http.HandlerFunc) {
+	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		h(w, r)
+		httpRequestsTotal.WithLabelValues(name).Inc()
+		httpRequestDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	})
+}
+
+// timeStoreCall records how long a Store call took under op.
+func timeStoreCall(op string, start time.Time) {
+	storeCommandDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+}
+
+// enqueueUpdate sends dist to updateChan, tracking queue depth. If
+// -update-queue-drop is set, it drops the update and increments a counter
+// instead of blocking when the channel is full.
+func enqueueUpdate(dist *Distribution) {
+	if *updateQueueDrop {
+		select {
+		case updateChan <- dist:
+		default:
+			updateQueueDropped.Inc()
+		}
+	} else {
+		updateChan <- dist
+	}
+	updateQueueDepth.Set(float64(len(updateChan)))
+}
+
+func registerMetricsHandler() {
+	http.Handle("/metrics", promhttp.Handler())
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	redisHost = flag.String("redis-host", "", "Redis host in the form host:port:db.")
+
+	redisPoolSize     = flag.Int("redis-pool-size", 10, "Maximum number of socket connections in the redis pool")
+	redisDialTimeout  = flag.Duration("redis-dial-timeout", 5*time.Second, "Timeout for establishing new redis connections")
+	redisReadTimeout  = flag.Duration("redis-read-timeout", 3*time.Second, "Timeout for socket reads from redis")
+	redisWriteTimeout = flag.Duration("redis-write-timeout", 3*time.Second, "Timeout for socket writes to redis")
+	redisMaxRetries   = flag.Int("redis-max-retries", 3, "Maximum number of retries for a failed redis command")
+)
+
+// RedisStore is the Store implementation backed by redis, for deployments
+// that want distributions shared across multiple forgettable instances.
+type RedisStore struct {
+	client redis.UniversalClient
+}
+
+func NewRedisStore() *RedisStore {
+	return &RedisStore{client: ConnectRedis()}
+}
+
+func ConnectRedis() redis.UniversalClient {
+	parts := strings.Split(*redisHost, ":")
+	if len(parts) != 3 {
+		logger.Fatal().Msg("redis-host must be in the form host:port:db")
+	}
+
+	db, err := strconv.Atoi(parts[2])
+	if err != nil {
+		logger.Fatal().Str("redis_host", *redisHost).Msg("redis-host db component must be numeric")
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:         fmt.Sprintf("%s:%s", parts[0], parts[1]),
+		DB:           db,
+		PoolSize:     *redisPoolSize,
+		DialTimeout:  *redisDialTimeout,
+		ReadTimeout:  *redisReadTimeout,
+		WriteTimeout: *redisWriteTimeout,
+		MaxRetries:   *redisMaxRetries,
+	})
+
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		logger.Fatal().Str("redis_host", *redisHost).Err(err).Msg("could not connect to redis")
+	}
+
+	logger.Info().Str("redis_host", *redisHost).Msg("connected to redis")
+	return client
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
+
+func (s *RedisStore) Incr(ctx context.Context, dist, field string, n int) error {
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(ctx, dist, field, int64(n))
+		pipe.HIncrBy(ctx, dist, "_Z", int64(n))
+		pipe.HSetNX(ctx, dist, "_T", int(time.Now().Unix()))
+		return nil
+	})
+	return err
+}
+
+func (s *RedisStore) BulkIncr(ctx context.Context, incrs []Increment) error {
+	zTotals := make(map[string]int64, len(incrs))
+	now := int64(time.Now().Unix())
+
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, inc := range incrs {
+			pipe.HIncrBy(ctx, inc.Distribution, inc.Field, int64(inc.N))
+			zTotals[inc.Distribution] += int64(inc.N)
+		}
+		for dist, z := range zTotals {
+			pipe.HIncrBy(ctx, dist, "_Z", z)
+			pipe.HSetNX(ctx, dist, "_T", now)
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *RedisStore) GetField(ctx context.Context, dist, field string) (count, Z, T int, rate float64, err error) {
+	data, err := s.client.HMGet(ctx, dist, field, "_Z", "_T", "_R").Result()
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	if len(data) != 4 {
+		return 0, 0, 0, 0, fmt.Errorf("unexpected reply length for %s", dist)
+	}
+
+	if raw, ok := data[3].(string); ok {
+		fmt.Fscan(strings.NewReader(raw), &rate)
+	}
+	return redisInt(data[0]), redisInt(data[1]), redisInt(data[2]), rate, nil
+}
+
+func (s *RedisStore) GetDist(ctx context.Context, dist string) (*Distribution, error) {
+	data, err := s.client.HGetAll(ctx, dist).Result()
+	if err != nil {
+		return nil, fmt.Errorf("Could not fetch data for %s: %s", dist, err)
+	}
+
+	d := &Distribution{Name: dist, Data: make(map[string]int)}
+	for k, raw := range data {
+		if k == "_R" {
+			var rate float64
+			n, err := fmt.Fscan(strings.NewReader(raw), &rate)
+			if n == 1 && err == nil {
+				d.Rate = rate
+			}
+		} else {
+			v, err := strconv.Atoi(raw)
+			if err != nil {
+				logger.Warn().Str("distribution", dist).Str("value", raw).Err(err).Msg("could not read field from distribution")
+			}
+			if k == "_Z" {
+				continue
+			} else if k == "_T" {
+				d.T = v
+			} else {
+				d.Data[k] = v
+				d.Z += v
+			}
+		}
+	}
+	return d, nil
+}
+
+func (s *RedisStore) WriteBack(ctx context.Context, d *Distribution) error {
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for k, v := range d.Data {
+			pipe.HSet(ctx, d.Name, k, v)
+		}
+		pipe.HSet(ctx, d.Name, "_Z", d.Z)
+		pipe.HSet(ctx, d.Name, "_T", d.T)
+		return nil
+	})
+	return err
+}
+
+// redisInt converts a value returned by HMGet (nil for a missing field,
+// otherwise a string) into an int, treating anything unparsable as zero.
+func redisInt(v interface{}) int {
+	s, ok := v.(string)
+	if !ok {
+		return 0
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+)
+
+var backend = flag.String("backend", "redis", "Storage backend to use: 'redis' (requires -redis-host) or 'bolt' (embedded, single-node, requires -bolt-path)")
+
+// Increment is a single field increment, as submitted to /bulk_incr.
+type Increment struct {
+	Distribution string
+	Field        string
+	N            int
+}
+
+// Store is the persistence interface distributions are read from and
+// written to. Handlers and the update workers talk to a Store rather than
+// to redis directly, so an embedded single-node backend can be swapped in
+// without touching any of them.
+type Store interface {
+	// Incr atomically increments dist's field (and its _Z aggregate) by n,
+	// setting _T to now if it hasn't been set yet.
+	Incr(ctx context.Context, dist, field string, n int) error
+
+	// BulkIncr applies a batch of increments in a single round-trip where
+	// the backend supports it, incrementing each distribution's _Z
+	// aggregate by the sum of its increments and setting _T if unset.
+	BulkIncr(ctx context.Context, incrs []Increment) error
+
+	// GetField returns field's raw count alongside the distribution's Z, T
+	// and rate, with no decay applied.
+	GetField(ctx context.Context, dist, field string) (count, Z, T int, rate float64, err error)
+
+	// GetDist returns the full raw distribution, with no decay applied.
+	GetDist(ctx context.Context, dist string) (*Distribution, error)
+
+	// WriteBack persists a fully computed (decayed) distribution.
+	WriteBack(ctx context.Context, d *Distribution) error
+}
+
+var store Store
+
+// NewStore constructs the Store selected by -backend.
+func NewStore(name string) (Store, error) {
+	switch name {
+	case "redis":
+		return NewRedisStore(), nil
+	case "bolt":
+		return NewBoltStore(*boltPath)
+	default:
+		return nil, fmt.Errorf("unknown backend %q, must be 'redis' or 'bolt'", name)
+	}
+}